@@ -3,8 +3,10 @@ package source
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	"text/template"
 
@@ -12,17 +14,24 @@ import (
 	contourapi "github.com/projectcontour/contour/apis/projectcontour/v1"
 	contourinformers "github.com/projectcontour/contour/apis/generated/informers/externalversions"
 	extinformers "github.com/projectcontour/contour/apis/generated/informers/externalversions/projectcontour/v1"
-	"github.com/pkg/errors"	
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 
 	"sigs.k8s.io/external-dns/endpoint"
 )
 
+// clusterWeightAnnotationKey lets a federated HTTPProxy set a per-cluster
+// weight for a hostname that is served from more than one cluster; see
+// NewMultiClusterContourHTTPProxySource.
+const clusterWeightAnnotationKey = "external-dns.alpha.kubernetes.io/cluster-weight"
+
 type httpProxySource struct {
 	kubeClient       kubernetes.Interface
 	contourClient    contour.Interface
@@ -34,6 +43,35 @@ type httpProxySource struct {
 	ignoreHostnameAnnotation bool
 	checkHTTPProxyHealth bool
 	httpProxyInformer extinformers.HTTPProxyInformer
+	// includeInformer resolves spec.includes children. It is the same as
+	// httpProxyInformer when this source isn't namespace-scoped; when it is,
+	// httpProxyInformer only watches namespace, which would make a
+	// cross-namespace include invisible, so includeInformer watches
+	// cluster-wide instead. See endpointsFromIncludes.
+	includeInformer extinformers.HTTPProxyInformer
+	// serviceInformer caches the Contour envoy Service so LB targets are read
+	// from the local informer cache instead of an apiserver Get per proxy.
+	serviceInformer coreinformers.ServiceInformer
+	lbNamespace     string
+	lbName          string
+
+	// lbTargets memoizes the result of targetsFromContourLoadBalancer for the
+	// lifetime of a single Endpoints() call; reset at the top of Endpoints().
+	lbTargetsMu       sync.Mutex
+	lbTargetsComputed bool
+	lbTargets         endpoint.Targets
+	lbTargetsErr      error
+	// clusterAlias identifies the cluster this source reads from when it is
+	// one member of a NewMultiClusterContourHTTPProxySource. Empty for a
+	// standalone, single-cluster source.
+	clusterAlias string
+	// requireValidStatus gates endpoint generation on isHTTPProxyValid. When
+	// false, an HTTPProxy whose status doesn't indicate validity still
+	// publishes DNS, logging a one-time warning per proxy instead.
+	requireValidStatus bool
+
+	warnedInvalidMu sync.Mutex
+	warnedInvalid   map[string]bool
 }
 
 func NewContourHTTPProxySource(
@@ -46,6 +84,7 @@ func NewContourHTTPProxySource(
 	combineFqdnAnnotation bool,
 	ignoreHostnameAnnotation bool,
 	checkHTTPProxyHealth bool,
+	requireValidStatus bool,
 ) (Source, error) {
 	var (
 		tmpl *template.Template
@@ -60,7 +99,8 @@ func NewContourHTTPProxySource(
 		}
 	}
 
-	if _, _, err = parseContourLoadBalancerService(contourLoadBalancerService); err != nil {
+	lbNamespace, lbName, err := parseContourLoadBalancerService(contourLoadBalancerService)
+	if err != nil {
 		return nil, err
 	}
 
@@ -76,7 +116,7 @@ func NewContourHTTPProxySource(
 	// Add default resource event handlers to properly initialize informer.
 	httpProxyInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {	
+			AddFunc: func(obj interface{}) {
 			},
 		},
 	)
@@ -92,6 +132,61 @@ func NewContourHTTPProxySource(
 		return nil, fmt.Errorf("failed to sync cache: %v", err)
 	}
 
+	// spec.includes can point at an HTTPProxy in any namespace, regardless of
+	// whether this source is namespace-scoped, so includes resolution needs
+	// its own cluster-wide informer - httpProxyInformer's cache only ever
+	// contains namespace. When namespace is already "" the two informers
+	// would watch the identical scope, so just share the one we already
+	// built instead of doubling the apiserver watch for no reason.
+	includeInformer := httpProxyInformer
+	if namespace != "" {
+		includeInformerFactory := contourinformers.NewSharedInformerFactoryWithOptions(contourClient, 0)
+		includeInformer = includeInformerFactory.Projectcontour().V1().HTTPProxies()
+
+		includeInformer.Informer().AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+				},
+			},
+		)
+
+		includeInformerFactory.Start(wait.NeverStop)
+
+		err = wait.Poll(time.Second, 60*time.Second, func() (bool, error) {
+			return includeInformer.Informer().HasSynced() == true, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync cluster-wide httpproxy cache for includes resolution: %v", err)
+		}
+	}
+
+	// Likewise, cache the Contour envoy Service via its own shared informer so
+	// targetsFromContourLoadBalancer reads the local cache instead of issuing
+	// an apiserver Get for every HTTPProxy that lacks a target annotation.
+	serviceInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
+		kubeClient,
+		0,
+		kubeinformers.WithNamespace(lbNamespace),
+	)
+	serviceInformer := serviceInformerFactory.Core().V1().Services()
+
+	serviceInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+			},
+		},
+	)
+
+	// TODO informer is not explicitly stopped since controller is not passing in its channel.
+	serviceInformerFactory.Start(wait.NeverStop)
+
+	err = wait.Poll(time.Second, 60*time.Second, func() (bool, error) {
+		return serviceInformer.Informer().HasSynced() == true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync contour load balancer service cache: %v", err)
+	}
+
 	fmt.Printf("creating httpproxy source with namespace %s \n", namespace)
 	return &httpProxySource{
 		kubeClient:                 kubeClient,
@@ -103,10 +198,18 @@ func NewContourHTTPProxySource(
 		combineFQDNAnnotation:      combineFqdnAnnotation,
 		ignoreHostnameAnnotation:   ignoreHostnameAnnotation,
 		httpProxyInformer:       httpProxyInformer,
+		includeInformer:            includeInformer,
+		serviceInformer:            serviceInformer,
+		lbNamespace:                lbNamespace,
+		lbName:                     lbName,
+		requireValidStatus:         requireValidStatus,
+		warnedInvalid:              make(map[string]bool),
 	}, nil
 }
 
 func (sc *httpProxySource) Endpoints() ([]*endpoint.Endpoint, error) {
+	sc.resetLBTargetsCache()
+
 	hps, err := sc.httpProxyInformer.Lister().HTTPProxies(sc.namespace).List(labels.Everything())
 	if err != nil {
 		return nil, err
@@ -126,7 +229,7 @@ func (sc *httpProxySource) Endpoints() ([]*endpoint.Endpoint, error) {
 			log.Debugf("Skipping httpproxy %s/%s because controller value does not match, found: %s, requhped: %s",
 				hp.Namespace, hp.Name, controller, controllerAnnotationValue)
 			continue
-		} else if hp.Status.CurrentStatus != "valid" {
+		} else if !sc.checkHTTPProxyValid(hp) {
 			log.Debugf("Skipping httpproxy %s/%s because it is not valid", hp.Namespace, hp.Name)
 			continue
 		}
@@ -158,6 +261,14 @@ func (sc *httpProxySource) Endpoints() ([]*endpoint.Endpoint, error) {
 		log.Debugf("Endpoints generated from httpproxy: %s/%s: %v", hp.Namespace, hp.Name, hpEndpoints)
 		sc.setResourceLabel(hp, hpEndpoints)
 		endpoints = append(endpoints, hpEndpoints...)
+
+		if hp.Spec.VirtualHost != nil {
+			includeEndpoints, err := sc.endpointsFromIncludes(hp, map[string]bool{includeVisitKey(hp): true})
+			if err != nil {
+				return nil, err
+			}
+			endpoints = append(endpoints, includeEndpoints...)
+		}
 	}
 
 	for _, ep := range endpoints {
@@ -192,7 +303,7 @@ func (sc *httpProxySource) endpointsFromTemplate(hp *contourapi.HTTPProxy) ([]*e
 		}
 	}
 
-	providerSpecific, setIdentifier := getProviderSpecificAnnotations(hp.Annotations)
+	providerSpecific, setIdentifier := sc.providerSpecificAnnotations(hp.Annotations)
 
 	var endpoints []*endpoint.Endpoint
 	// splits the FQDN template and removes the trailing periods
@@ -236,7 +347,7 @@ func (sc *httpProxySource) filterByAnnotations(httpProxies []*contourapi.HTTPPro
 }
 
 func (sc *httpProxySource) endpointsFromHttpProxy(hp *contourapi.HTTPProxy) ([]*endpoint.Endpoint, error) {
-	if hp.Status.CurrentStatus != "valid" {
+	if !sc.checkHTTPProxyValid(hp) {
 		log.Warn(errors.Errorf("cannot generate endpoints for httpproxy with status %s", hp.Status.CurrentStatus))
 		return nil, nil
 	}
@@ -257,7 +368,7 @@ func (sc *httpProxySource) endpointsFromHttpProxy(hp *contourapi.HTTPProxy) ([]*
 		}
 	}
 
-	providerSpecific, setIdentifier := getProviderSpecificAnnotations(hp.Annotations)
+	providerSpecific, setIdentifier := sc.providerSpecificAnnotations(hp.Annotations)
 
 	if virtualHost := hp.Spec.VirtualHost; virtualHost != nil {
 		if fqdn := virtualHost.Fqdn; fqdn != "" {
@@ -276,29 +387,225 @@ func (sc *httpProxySource) endpointsFromHttpProxy(hp *contourapi.HTTPProxy) ([]*
 	return endpoints, nil
 }
 
+// includeVisitKey returns the key used to track visited HTTPProxies while
+// walking an includes delegation chain.
+func includeVisitKey(hp *contourapi.HTTPProxy) string {
+	return hp.Namespace + "/" + hp.Name
+}
+
+// endpointsFromIncludes recursively resolves hp.Spec.Includes through
+// includeInformer, publishing each child's own hostname-annotation endpoints
+// (getHostnamesFromAnnotations, per endpointsFromHttpProxy) under that
+// child's own resource label - the delegated traffic for those hostnames is
+// served by the child, not the root, so it belongs to the child. visited
+// guards against include cycles and is keyed by "namespace/name".
+//
+// A child is only published here when sc.namespace scoping means the
+// top-level per-hp loop in Endpoints() could not have seen it directly: that
+// loop already lists every HTTPProxy in sc.namespace (or, when sc.namespace
+// is "", every HTTPProxy in the cluster), so publishing a child it already
+// covers here too would duplicate the same hostname under two different
+// resource labels. We still always recurse into the child's own includes,
+// regardless of whether it was published here, so a root's delegation chain
+// is followed to the end even through children with no virtual host of
+// their own that the top-level loop won't walk.
+//
+// A child that cannot be found, or that is not valid, is skipped silently
+// and logged at debug level.
+func (sc *httpProxySource) endpointsFromIncludes(hp *contourapi.HTTPProxy, visited map[string]bool) ([]*endpoint.Endpoint, error) {
+	var endpoints []*endpoint.Endpoint
+
+	for _, include := range hp.Spec.Includes {
+		childNamespace := include.Namespace
+		if childNamespace == "" {
+			childNamespace = hp.Namespace
+		}
+
+		child, err := sc.includeInformer.Lister().HTTPProxies(childNamespace).Get(include.Name)
+		if err != nil {
+			log.Debugf("Skipping httpproxy include %s/%s because it could not be found: %v", childNamespace, include.Name, err)
+			continue
+		}
+
+		if !sc.checkHTTPProxyValid(child) {
+			log.Debugf("Skipping httpproxy include %s/%s because it is not valid", childNamespace, include.Name)
+			continue
+		}
+
+		key := includeVisitKey(child)
+		if visited[key] {
+			log.Debugf("Skipping httpproxy include %s because it was already visited in this delegation chain", key)
+			continue
+		}
+		visited[key] = true
+
+		if sc.namespace != "" && childNamespace != sc.namespace {
+			childEndpoints, err := sc.endpointsFromHttpProxy(child)
+			if err != nil {
+				return nil, err
+			}
+			sc.setResourceLabel(child, childEndpoints)
+			endpoints = append(endpoints, childEndpoints...)
+		}
+
+		grandchildEndpoints, err := sc.endpointsFromIncludes(child, visited)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, grandchildEndpoints...)
+	}
+
+	return endpoints, nil
+}
+
 func (sc *httpProxySource) setResourceLabel(hp *contourapi.HTTPProxy, endpoints []*endpoint.Endpoint) {
+	label := fmt.Sprintf("hp/%s/%s", hp.Namespace, hp.Name)
+	if sc.clusterAlias != "" {
+		// Extend the resource label with the cluster alias so ownership TXT
+		// records stay unambiguous when the same proxy name/namespace pair
+		// exists in more than one cluster behind a multi-cluster source.
+		label = fmt.Sprintf("hp/%s/%s/%s", sc.clusterAlias, hp.Namespace, hp.Name)
+	}
 	for _, ep := range endpoints {
-		ep.Labels[endpoint.ResourceLabelKey] = fmt.Sprintf("hp/%s/%s", hp.Namespace, hp.Name)
+		ep.Labels[endpoint.ResourceLabelKey] = label
 	}
 }
 
-func (sc *httpProxySource) targetsFromContourLoadBalancer() (targets endpoint.Targets, err error) {
-	lbNamespace, lbName, err := parseContourLoadBalancerService(sc.contourLoadBalancerService)
+// providerSpecificAnnotations wraps getProviderSpecificAnnotations to also
+// thread the cluster-weight annotation through as a provider-specific
+// property when this source is a member of a multi-cluster source, so
+// downstream weighted-routing providers (Route53, etc.) can split traffic
+// between clusters that both answer for the same FQDN.
+func (sc *httpProxySource) providerSpecificAnnotations(annotations map[string]string) (endpoint.ProviderSpecific, string) {
+	providerSpecific, setIdentifier := getProviderSpecificAnnotations(annotations)
+	if sc.clusterAlias != "" {
+		if weight, ok := annotations[clusterWeightAnnotationKey]; ok {
+			providerSpecific = append(providerSpecific, endpoint.ProviderSpecificProperty{
+				Name:  clusterWeightAnnotationKey,
+				Value: weight,
+			})
+		}
+	}
+	return providerSpecific, setIdentifier
+}
+
+// isHTTPProxyValid reports whether hp's status indicates it passed Contour's
+// validation. github.com/projectcontour/contour v1.1.0 - the version pinned
+// in go.mod - only has the deprecated CurrentStatus string on its
+// contourapi.Status type; it predates the standard Conditions model
+// (status.conditions[type=Valid,status=True]) that later Contour versions
+// expose instead. conditionsValidity reads that shape through reflection
+// rather than a compile-time field reference, so this prefers it the moment
+// the dependency is bumped to a version that has it, with no code change
+// required here; until then status.Conditions doesn't exist, conditionsValidity
+// reports ok=false, and this falls back to CurrentStatus.
+func isHTTPProxyValid(hp *contourapi.HTTPProxy) bool {
+	if valid, ok := conditionsValidity(hp.Status); ok {
+		return valid
+	}
+	return hp.Status.CurrentStatus == "valid"
+}
+
+// conditionsValidity looks for a "Conditions" field on status - a slice of
+// structs each with "Type" and "Status" fields, the shape newer Contour
+// versions report validity with - and reports whether one has Type "Valid"
+// and Status "True". ok is false when status has no such field at all (e.g.
+// the v1.1.0 Status this repo currently builds against), telling the caller
+// to fall back to CurrentStatus instead.
+func conditionsValidity(status interface{}) (valid bool, ok bool) {
+	conditions := reflect.ValueOf(status).FieldByName("Conditions")
+	if !conditions.IsValid() || conditions.Kind() != reflect.Slice {
+		return false, false
+	}
+
+	for i := 0; i < conditions.Len(); i++ {
+		condition := conditions.Index(i)
+		condType := condition.FieldByName("Type")
+		condStatus := condition.FieldByName("Status")
+		if !condType.IsValid() || !condStatus.IsValid() {
+			continue
+		}
+		if fmt.Sprintf("%v", condType.Interface()) == "Valid" {
+			return fmt.Sprintf("%v", condStatus.Interface()) == "True", true
+		}
+	}
+
+	return false, true
+}
+
+// checkHTTPProxyValid gates on isHTTPProxyValid. With requireValidStatus set
+// it behaves exactly as before: an invalid proxy is rejected.
+// With requireValidStatus false, an invalid proxy is still accepted so
+// operators running Contour in a mode where status isn't populated - e.g.
+// mid-upgrade, or with a non-default controller name - can keep publishing
+// DNS; a warning is logged once per proxy rather than on every reconcile.
+// requireValidStatus comes only from the NewContourHTTPProxySource argument;
+// no command-line flag sets it.
+func (sc *httpProxySource) checkHTTPProxyValid(hp *contourapi.HTTPProxy) bool {
+	if isHTTPProxyValid(hp) {
+		return true
+	}
+	if sc.requireValidStatus {
+		return false
+	}
+
+	key := hp.Namespace + "/" + hp.Name
+	sc.warnedInvalidMu.Lock()
+	alreadyWarned := sc.warnedInvalid[key]
+	sc.warnedInvalid[key] = true
+	sc.warnedInvalidMu.Unlock()
+
+	if !alreadyWarned {
+		log.Warnf("httpproxy %s has status %q but requireValidStatus is false, publishing DNS anyway", key, hp.Status.CurrentStatus)
+	}
+	return true
+}
+
+// resetLBTargetsCache clears the per-Endpoints()-call memoization of
+// targetsFromContourLoadBalancer. Call once at the top of Endpoints().
+func (sc *httpProxySource) resetLBTargetsCache() {
+	sc.lbTargetsMu.Lock()
+	sc.lbTargetsComputed = false
+	sc.lbTargets = nil
+	sc.lbTargetsErr = nil
+	sc.lbTargetsMu.Unlock()
+}
+
+// targetsFromContourLoadBalancer returns the Contour envoy Service's targets,
+// read from the local Service informer cache rather than an apiserver Get.
+// The result is memoized for the lifetime of a single Endpoints() call, since
+// every HTTPProxy without its own target annotation resolves to the same
+// lbNamespace/lbName Service.
+func (sc *httpProxySource) targetsFromContourLoadBalancer() (endpoint.Targets, error) {
+	sc.lbTargetsMu.Lock()
+	defer sc.lbTargetsMu.Unlock()
+
+	if sc.lbTargetsComputed {
+		return sc.lbTargets, sc.lbTargetsErr
+	}
+
+	sc.lbTargets, sc.lbTargetsErr = sc.lookupLBTargets()
+	sc.lbTargetsComputed = true
+	return sc.lbTargets, sc.lbTargetsErr
+}
+
+func (sc *httpProxySource) lookupLBTargets() (targets endpoint.Targets, err error) {
+	svc, err := sc.serviceInformer.Lister().Services(sc.lbNamespace).Get(sc.lbName)
 	if err != nil {
-		return nil, err
+		// A failed lookup used to be logged and silently treated as zero
+		// targets, which could delete existing DNS records; surface it as an
+		// error instead so the controller backs off.
+		return nil, fmt.Errorf("failed to get contour load balancer service %s/%s: %v", sc.lbNamespace, sc.lbName, err)
 	}
-	if svc, err := sc.kubeClient.CoreV1().Services(lbNamespace).Get(lbName, metav1.GetOptions{}); err != nil {
-		log.Warn(err)
-	} else {
-		for _, lb := range svc.Status.LoadBalancer.Ingress {
-			if lb.IP != "" {
-				targets = append(targets, lb.IP)
-			}
-			if lb.Hostname != "" {
-				targets = append(targets, lb.Hostname)
-			}
+
+	for _, lb := range svc.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			targets = append(targets, lb.IP)
+		}
+		if lb.Hostname != "" {
+			targets = append(targets, lb.Hostname)
 		}
 	}
 
-	return
+	return targets, nil
 }
\ No newline at end of file
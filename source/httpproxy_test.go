@@ -0,0 +1,294 @@
+package source
+
+import (
+	"testing"
+
+	contourapi "github.com/projectcontour/contour/apis/projectcontour/v1"
+	fakeContour "github.com/projectcontour/contour/apis/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeKube "k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func newTestHTTPProxySource(t *testing.T, namespace string, proxies []*contourapi.HTTPProxy) *httpProxySource {
+	t.Helper()
+
+	lbService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy", Namespace: "projectcontour"},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "8.8.8.8"}},
+			},
+		},
+	}
+	kubeClient := fakeKube.NewSimpleClientset(lbService)
+
+	contourClient := fakeContour.NewSimpleClientset()
+	for _, p := range proxies {
+		_, err := contourClient.ProjectcontourV1().HTTPProxies(p.Namespace).Create(p)
+		require.NoError(t, err)
+	}
+
+	src, err := NewContourHTTPProxySource(
+		kubeClient,
+		contourClient,
+		"projectcontour/envoy",
+		namespace,
+		"",
+		"",
+		false,
+		false,
+		false,
+		true,
+	)
+	require.NoError(t, err)
+
+	return src.(*httpProxySource)
+}
+
+func endpointsForDNSName(eps []*endpoint.Endpoint, dnsName string) []*endpoint.Endpoint {
+	var matched []*endpoint.Endpoint
+	for _, ep := range eps {
+		if ep.DNSName == dnsName {
+			matched = append(matched, ep)
+		}
+	}
+	return matched
+}
+
+// TestEndpointsFromHttpProxyIncludesUnscoped covers the common, unscoped
+// (namespace == "") deployment mode: the top-level per-hp loop already lists
+// every HTTPProxy in the cluster, including included children, so the
+// child's hostname-annotation endpoint must come from that loop - the
+// includes walk must not publish it a second time.
+func TestEndpointsFromHttpProxyIncludesUnscoped(t *testing.T) {
+	root := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "root", Namespace: "root-ns"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "example.com"},
+			Includes: []contourapi.Include{
+				{Name: "child", Namespace: "child-ns"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+	child := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "child",
+			Namespace: "child-ns",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "child.example.com",
+				targetAnnotationKey:   "1.2.3.4",
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	src := newTestHTTPProxySource(t, "", []*contourapi.HTTPProxy{root, child})
+
+	eps, err := src.Endpoints()
+	require.NoError(t, err)
+
+	childEndpoints := endpointsForDNSName(eps, "child.example.com")
+	require.Len(t, childEndpoints, 1, "the child's own hostname annotation must be published exactly once, got %+v", eps)
+	assert.Contains(t, childEndpoints[0].Targets, "1.2.3.4")
+	assert.Equal(t, "hp/child-ns/child", childEndpoints[0].Labels[endpoint.ResourceLabelKey])
+}
+
+// TestEndpointsFromHttpProxyIncludesCrossNamespaceScoped covers a
+// namespace-scoped source (namespace == root's namespace) with a child
+// living in a different namespace: the top-level per-hp loop only lists
+// root's namespace, so it can never see the child directly, and only the
+// includes walk (via includeInformer's cluster-wide cache) can publish it.
+func TestEndpointsFromHttpProxyIncludesCrossNamespaceScoped(t *testing.T) {
+	root := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "root", Namespace: "root-ns"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "example.com"},
+			Includes: []contourapi.Include{
+				{Name: "child", Namespace: "child-ns"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+	child := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "child",
+			Namespace: "child-ns",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "child.example.com",
+				targetAnnotationKey:   "1.2.3.4",
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	src := newTestHTTPProxySource(t, "root-ns", []*contourapi.HTTPProxy{root, child})
+
+	eps, err := src.Endpoints()
+	require.NoError(t, err)
+
+	childEndpoints := endpointsForDNSName(eps, "child.example.com")
+	require.Len(t, childEndpoints, 1, "a cross-namespace child must still be published when the source is namespace-scoped, got %+v", eps)
+	assert.Contains(t, childEndpoints[0].Targets, "1.2.3.4")
+	assert.Equal(t, "hp/child-ns/child", childEndpoints[0].Labels[endpoint.ResourceLabelKey])
+}
+
+// TestEndpointsFromHttpProxyIncludesSameNamespaceScoped covers a
+// namespace-scoped source where the child lives in that same namespace: the
+// top-level per-hp loop already lists it directly, so the includes walk must
+// not publish it a second time.
+func TestEndpointsFromHttpProxyIncludesSameNamespaceScoped(t *testing.T) {
+	root := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "root", Namespace: "root-ns"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "example.com"},
+			Includes: []contourapi.Include{
+				{Name: "child"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+	child := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "child",
+			Namespace: "root-ns",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "child.example.com",
+				targetAnnotationKey:   "1.2.3.4",
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	src := newTestHTTPProxySource(t, "root-ns", []*contourapi.HTTPProxy{root, child})
+
+	eps, err := src.Endpoints()
+	require.NoError(t, err)
+
+	childEndpoints := endpointsForDNSName(eps, "child.example.com")
+	require.Len(t, childEndpoints, 1, "a same-namespace child must be published exactly once, got %+v", eps)
+}
+
+func TestEndpointsFromHttpProxyIncludesCycle(t *testing.T) {
+	a := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns-a"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "cycle.example.com"},
+			Includes: []contourapi.Include{
+				{Name: "b", Namespace: "ns-b"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+	b := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "b",
+			Namespace: "ns-b",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "b.example.com",
+				targetAnnotationKey:   "5.6.7.8",
+			},
+		},
+		Spec: contourapi.HTTPProxySpec{
+			Includes: []contourapi.Include{
+				{Name: "a", Namespace: "ns-a"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	src := newTestHTTPProxySource(t, "ns-a", []*contourapi.HTTPProxy{a, b})
+
+	eps, err := src.endpointsFromIncludes(a, map[string]bool{includeVisitKey(a): true})
+	require.NoError(t, err)
+
+	require.Len(t, eps, 1, "b->a is a cycle back to the already-visited root, b must be walked exactly once via a->b and never re-entered via b->a, got %+v", eps)
+	assert.Equal(t, "b.example.com", eps[0].DNSName)
+	assert.Contains(t, eps[0].Targets, "5.6.7.8")
+}
+
+func TestEndpointsFromHttpProxyIncludesMissingOrInvalidChild(t *testing.T) {
+	rootMissing := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "root-missing", Namespace: "root-ns"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "missing.example.com"},
+			Includes: []contourapi.Include{
+				{Name: "does-not-exist", Namespace: "child-ns"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	invalidChild := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "invalid-child",
+			Namespace: "child-ns",
+			Annotations: map[string]string{
+				hostnameAnnotationKey: "invalid-child.example.com",
+				targetAnnotationKey:   "9.9.9.9",
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "NotReconciled"},
+	}
+	rootInvalid := &contourapi.HTTPProxy{
+		ObjectMeta: metav1.ObjectMeta{Name: "root-invalid", Namespace: "root-ns"},
+		Spec: contourapi.HTTPProxySpec{
+			VirtualHost: &contourapi.VirtualHost{Fqdn: "invalid.example.com"},
+			Includes: []contourapi.Include{
+				{Name: "invalid-child", Namespace: "child-ns"},
+			},
+		},
+		Status: contourapi.Status{CurrentStatus: "valid"},
+	}
+
+	src := newTestHTTPProxySource(t, "root-ns", []*contourapi.HTTPProxy{rootMissing, rootInvalid, invalidChild})
+
+	missingEps, err := src.endpointsFromIncludes(rootMissing, map[string]bool{includeVisitKey(rootMissing): true})
+	require.NoError(t, err)
+	assert.Empty(t, missingEps, "a missing include should be skipped, not error")
+
+	invalidEps, err := src.endpointsFromIncludes(rootInvalid, map[string]bool{includeVisitKey(rootInvalid): true})
+	require.NoError(t, err)
+	assert.Empty(t, invalidEps, "an invalid include should be skipped, not error")
+}
+
+func TestIsHTTPProxyValid(t *testing.T) {
+	assert.True(t, isHTTPProxyValid(&contourapi.HTTPProxy{Status: contourapi.Status{CurrentStatus: "valid"}}))
+	assert.False(t, isHTTPProxyValid(&contourapi.HTTPProxy{Status: contourapi.Status{CurrentStatus: "NotReconciled"}}))
+	assert.False(t, isHTTPProxyValid(&contourapi.HTTPProxy{}))
+}
+
+// TestConditionsValidityPrefersConditionsOverFallback exercises the
+// reflection-based Conditions reader against a stand-in status shape, since
+// the v1.1.0 contourapi.Status this repo currently builds against has no
+// Conditions field to construct one against directly.
+func TestConditionsValidityPrefersConditionsOverFallback(t *testing.T) {
+	type condition struct {
+		Type   string
+		Status string
+	}
+	type statusWithConditions struct {
+		CurrentStatus string
+		Conditions    []condition
+	}
+
+	valid, ok := conditionsValidity(statusWithConditions{
+		Conditions: []condition{{Type: "Valid", Status: "True"}},
+	})
+	require.True(t, ok)
+	assert.True(t, valid)
+
+	valid, ok = conditionsValidity(statusWithConditions{
+		Conditions: []condition{{Type: "Valid", Status: "False"}},
+	})
+	require.True(t, ok)
+	assert.False(t, valid)
+
+	_, ok = conditionsValidity(contourapi.Status{CurrentStatus: "valid"})
+	assert.False(t, ok, "v1.1.0's Status has no Conditions field, so ok must be false so the caller falls back to CurrentStatus")
+}
@@ -0,0 +1,283 @@
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	contour "github.com/projectcontour/contour/apis/generated/clientset/versioned"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ClusterContourConfig identifies one member cluster of a federated HTTPProxy
+// source: how to reach its apiserver and Contour's load balancer Service, and
+// the alias used to disambiguate its records from every other cluster.
+type ClusterContourConfig struct {
+	// KubeConfig is the path to the kubeconfig file for this cluster. Empty
+	// uses in-cluster config, the same convention as the rest of external-dns.
+	KubeConfig string
+	// Context is the kubeconfig context to use. Empty uses the current context.
+	Context string
+	// ClusterAlias is a short, unique name for this cluster, used both in the
+	// resource label and as the default cluster-weight identifier.
+	ClusterAlias string
+	// ContourLoadBalancerService is "namespace/name" of this cluster's Contour
+	// envoy Service, passed straight through to NewContourHTTPProxySource.
+	ContourLoadBalancerService string
+}
+
+// clusterHTTPProxySource pairs one cluster's httpProxySource with the last
+// successfully generated set of endpoints for that cluster, so a transient
+// informer-sync failure in one cluster doesn't blank out its records.
+type clusterHTTPProxySource struct {
+	alias  string
+	source *httpProxySource
+
+	mu            sync.Mutex
+	lastEndpoints []*endpoint.Endpoint
+}
+
+type multiClusterHTTPProxySource struct {
+	clusters []*clusterHTTPProxySource
+}
+
+// NewMultiClusterContourHTTPProxySource builds a single Source that aggregates
+// HTTPProxy-derived endpoints from many clusters into one result set - the
+// same pattern Admiral uses to build a federated service registry from many
+// clusters. Each cluster gets its own Contour informer and kube client; when
+// the same FQDN is produced by proxies in more than one cluster, targets are
+// unioned (deduped, sorted) so external-dns writes a single multi-target
+// record instead of one cluster clobbering another.
+//
+// If a cluster's Endpoints() call fails, that cluster's last successful
+// result is served instead of failing the whole aggregation - see
+// clusterHTTPProxySource. Informers sync only here, at construction: if any
+// cluster's client or informers cannot be built or synced, this returns an
+// error and no source is built for any cluster.
+func NewMultiClusterContourHTTPProxySource(
+	clusters []ClusterContourConfig,
+	namespace string,
+	annotationFilter string,
+	fqdnTemplate string,
+	combineFqdnAnnotation bool,
+	ignoreHostnameAnnotation bool,
+	checkHTTPProxyHealth bool,
+	requireValidStatus bool,
+) (Source, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("at least one cluster must be configured")
+	}
+
+	mc := &multiClusterHTTPProxySource{}
+
+	for _, cluster := range clusters {
+		if cluster.ClusterAlias == "" {
+			return nil, fmt.Errorf("clusterAlias is required for cluster with contourLoadBalancerService %q", cluster.ContourLoadBalancerService)
+		}
+
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: cluster.KubeConfig},
+			&clientcmd.ConfigOverrides{CurrentContext: cluster.Context},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build client config for cluster %q: %v", cluster.ClusterAlias, err)
+		}
+
+		kubeClient, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build kube client for cluster %q: %v", cluster.ClusterAlias, err)
+		}
+
+		contourClient, err := contour.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build contour client for cluster %q: %v", cluster.ClusterAlias, err)
+		}
+
+		src, err := NewContourHTTPProxySource(
+			kubeClient,
+			contourClient,
+			cluster.ContourLoadBalancerService,
+			namespace,
+			annotationFilter,
+			fqdnTemplate,
+			combineFqdnAnnotation,
+			ignoreHostnameAnnotation,
+			checkHTTPProxyHealth,
+			requireValidStatus,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build httpproxy source for cluster %q: %v", cluster.ClusterAlias, err)
+		}
+
+		hps := src.(*httpProxySource)
+		hps.clusterAlias = cluster.ClusterAlias
+
+		mc.clusters = append(mc.clusters, &clusterHTTPProxySource{
+			alias:  cluster.ClusterAlias,
+			source: hps,
+		})
+	}
+
+	return mc, nil
+}
+
+func (mc *multiClusterHTTPProxySource) Endpoints() ([]*endpoint.Endpoint, error) {
+	var merged []*endpoint.Endpoint
+
+	for _, cluster := range mc.clusters {
+		eps, err := cluster.source.Endpoints()
+
+		cluster.mu.Lock()
+		if err != nil {
+			log.Warnf("cluster %q: failed to sync httpproxy endpoints, serving last known set: %v", cluster.alias, err)
+			eps = cluster.lastEndpoints
+		} else {
+			cluster.lastEndpoints = eps
+		}
+		cluster.mu.Unlock()
+
+		merged = append(merged, eps...)
+	}
+
+	return mergeByDNSName(merged), nil
+}
+
+// mergeByDNSName collapses endpoints that share a DNSName and RecordType -
+// typically the same FQDN served by proxies in different clusters - into one
+// endpoint whose targets are the deduped, sorted union of all of them. The
+// contributing clusters' resource labels and provider-specific properties
+// (including cluster-weight) are carried forward rather than dropped: see
+// mergeResourceLabels and mergeProviderSpecific.
+func mergeByDNSName(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	merged := make(map[string]*endpoint.Endpoint, len(endpoints))
+	order := make([]string, 0, len(endpoints))
+
+	for _, ep := range endpoints {
+		namespaceClusterWeight(ep)
+
+		key := ep.DNSName + "/" + ep.RecordType
+		existing, ok := merged[key]
+		if !ok {
+			// Merge into a copy, so a cluster's cached lastEndpoints never
+			// pick up other clusters' targets, labels or properties.
+			cp := *ep
+			cp.Targets = append(endpoint.Targets{}, ep.Targets...)
+			cp.Labels = make(endpoint.Labels, len(ep.Labels))
+			for k, v := range ep.Labels {
+				cp.Labels[k] = v
+			}
+			cp.ProviderSpecific = append(endpoint.ProviderSpecific{}, ep.ProviderSpecific...)
+			merged[key] = &cp
+			order = append(order, key)
+			continue
+		}
+
+		existing.Targets = dedupeTargets(append(existing.Targets, ep.Targets...))
+		sort.Sort(existing.Targets)
+
+		existing.Labels[endpoint.ResourceLabelKey] = mergeResourceLabels(
+			existing.Labels[endpoint.ResourceLabelKey],
+			ep.Labels[endpoint.ResourceLabelKey],
+		)
+		existing.ProviderSpecific = mergeProviderSpecific(existing.ProviderSpecific, ep.ProviderSpecific)
+	}
+
+	result := make([]*endpoint.Endpoint, 0, len(order))
+	for _, key := range order {
+		result = append(result, merged[key])
+	}
+	return result
+}
+
+// namespaceClusterWeight renames an endpoint's cluster-weight provider-specific
+// property to include the owning cluster's alias (read back off its resource
+// label). Without this, merging two clusters' endpoints for the same FQDN
+// would collapse both clusters' cluster-weight properties onto one
+// identically-named key, silently losing all but one cluster's weight.
+func namespaceClusterWeight(ep *endpoint.Endpoint) {
+	alias := clusterAliasFromResourceLabel(ep.Labels[endpoint.ResourceLabelKey])
+	if alias == "" {
+		return
+	}
+	for i, p := range ep.ProviderSpecific {
+		if p.Name == clusterWeightAnnotationKey {
+			ep.ProviderSpecific[i].Name = clusterWeightAnnotationKey + "/" + alias
+		}
+	}
+}
+
+// clusterAliasFromResourceLabel extracts the cluster alias from a resource
+// label of the form "hp/<cluster-alias>/<namespace>/<name>", as set by
+// httpProxySource.setResourceLabel when clusterAlias is non-empty. Returns ""
+// for a single-cluster "hp/<namespace>/<name>" label.
+func clusterAliasFromResourceLabel(label string) string {
+	parts := strings.Split(label, "/")
+	if len(parts) == 4 && parts[0] == "hp" {
+		return parts[1]
+	}
+	return ""
+}
+
+// resourceLabelMergeSeparator joins multiple clusters' resource labels into
+// one merged label value. It must never collide with a character the
+// TXT-registry label serialization (endpoint.Labels.Serialize /
+// NewLabelsFromString) itself uses as a delimiter - "," separates key=value
+// pairs and "=" separates key from value, so a "," inside a label value
+// breaks parsing of the entire label set, not just this field. ";" is never
+// valid in a Kubernetes namespace or name, so it can't appear in those parts
+// of "hp/<cluster-alias>/<namespace>/<name>". Cluster aliases are only
+// checked for being non-empty, so an alias containing ";" is not rejected.
+const resourceLabelMergeSeparator = ";"
+
+// mergeResourceLabels combines two clusters' resource labels for an endpoint
+// that was merged because both clusters answer for the same FQDN. Joining
+// every contributing "hp/<cluster-alias>/<namespace>/<name>" label - rather
+// than keeping only the first cluster's - keeps ownership of the merged
+// record traceable back to every HTTPProxy that contributed to it.
+func mergeResourceLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" || a == b {
+		return a
+	}
+	return a + resourceLabelMergeSeparator + b
+}
+
+// mergeProviderSpecific unions two clusters' provider-specific properties,
+// keeping every distinct Name/Value pair instead of silently dropping the
+// second cluster's (e.g. its namespaced cluster-weight property).
+func mergeProviderSpecific(a, b endpoint.ProviderSpecific) endpoint.ProviderSpecific {
+	seen := make(map[string]bool, len(a))
+	merged := append(endpoint.ProviderSpecific{}, a...)
+	for _, p := range a {
+		seen[p.Name+"="+p.Value] = true
+	}
+	for _, p := range b {
+		key := p.Name + "=" + p.Value
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+func dedupeTargets(targets endpoint.Targets) endpoint.Targets {
+	seen := make(map[string]bool, len(targets))
+	deduped := make(endpoint.Targets, 0, len(targets))
+	for _, t := range targets {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		deduped = append(deduped, t)
+	}
+	return deduped
+}
@@ -0,0 +1,86 @@
+package source
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func newClusterEndpoint(clusterAlias, namespace, name, dnsName string, weight string, targets ...string) *endpoint.Endpoint {
+	ep := endpoint.NewEndpoint(dnsName, "A", targets...)
+	ep.Labels = map[string]string{
+		endpoint.ResourceLabelKey: "hp/" + clusterAlias + "/" + namespace + "/" + name,
+	}
+	ep.ProviderSpecific = endpoint.ProviderSpecific{
+		{Name: clusterWeightAnnotationKey, Value: weight},
+	}
+	return ep
+}
+
+// TestMergeByDNSNameTwoClusters is the same-FQDN-from-two-clusters case this
+// package exists for: both clusters' targets, resource labels and
+// cluster-weight properties must all survive the merge, not just the first
+// cluster's.
+func TestMergeByDNSNameTwoClusters(t *testing.T) {
+	clusterA := newClusterEndpoint("cluster-a", "ns1", "n1", "example.com", "30", "1.1.1.1")
+	clusterB := newClusterEndpoint("cluster-b", "ns2", "n2", "example.com", "70", "2.2.2.2")
+
+	merged := mergeByDNSName([]*endpoint.Endpoint{clusterA, clusterB})
+
+	assert.Len(t, merged, 1)
+	ep := merged[0]
+
+	assert.ElementsMatch(t, []string{"1.1.1.1", "2.2.2.2"}, []string(ep.Targets))
+
+	label := ep.Labels[endpoint.ResourceLabelKey]
+	assert.Contains(t, label, "hp/cluster-a/ns1/n1")
+	assert.Contains(t, label, "hp/cluster-b/ns2/n2")
+	assert.NotContains(t, label, ",", "a merged resource label must not contain the registry's own key=value pair separator")
+
+	var weights []string
+	for _, p := range ep.ProviderSpecific {
+		if strings.HasPrefix(p.Name, clusterWeightAnnotationKey) {
+			weights = append(weights, p.Name+"="+p.Value)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		clusterWeightAnnotationKey + "/cluster-a=30",
+		clusterWeightAnnotationKey + "/cluster-b=70",
+	}, weights)
+}
+
+func TestMergeByDNSNameDistinctFQDNsAreNotMerged(t *testing.T) {
+	a := newClusterEndpoint("cluster-a", "ns1", "n1", "a.example.com", "10", "1.1.1.1")
+	b := newClusterEndpoint("cluster-b", "ns2", "n2", "b.example.com", "10", "2.2.2.2")
+
+	merged := mergeByDNSName([]*endpoint.Endpoint{a, b})
+
+	assert.Len(t, merged, 2)
+}
+
+func TestMergeResourceLabels(t *testing.T) {
+	assert.Equal(t, "hp/a/ns/n", mergeResourceLabels("", "hp/a/ns/n"))
+	assert.Equal(t, "hp/a/ns/n", mergeResourceLabels("hp/a/ns/n", ""))
+	assert.Equal(t, "hp/a/ns/n", mergeResourceLabels("hp/a/ns/n", "hp/a/ns/n"))
+	assert.Equal(t, "hp/a/ns/n;hp/b/ns2/n2", mergeResourceLabels("hp/a/ns/n", "hp/b/ns2/n2"))
+}
+
+func TestMergeProviderSpecificDedupesIdenticalPairs(t *testing.T) {
+	a := endpoint.ProviderSpecific{{Name: "foo", Value: "1"}}
+	b := endpoint.ProviderSpecific{{Name: "foo", Value: "1"}, {Name: "bar", Value: "2"}}
+
+	merged := mergeProviderSpecific(a, b)
+
+	assert.ElementsMatch(t, endpoint.ProviderSpecific{
+		{Name: "foo", Value: "1"},
+		{Name: "bar", Value: "2"},
+	}, merged)
+}
+
+func TestClusterAliasFromResourceLabel(t *testing.T) {
+	assert.Equal(t, "cluster-a", clusterAliasFromResourceLabel("hp/cluster-a/ns1/n1"))
+	assert.Equal(t, "", clusterAliasFromResourceLabel("hp/ns1/n1"))
+}